@@ -4,8 +4,13 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"reflect"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,17 +20,21 @@ const (
 	expectedCollection = "schools"
 )
 
-var (
-	errMissingDistrictField = fmt.Errorf(missingValuesErrTemplate, []string{"district_id"})
-)
+// testBinaryPath is the path to this test binary, captured before any test below reassigns
+// os.Args wholesale (most do, to feed Configure its own flag args). TestHelpOutput needs the
+// real path to re-exec itself, and os.Args[0] no longer reliably holds it by the time that test
+// runs.
+var testBinaryPath = os.Args[0]
 
 func TestConfigure(t *testing.T) {
 	for _, spec := range []struct {
-		context    string
-		args       []string
-		err        error
-		district   string
-		collection string
+		context         string
+		args            []string
+		err             error // compared with assert.Equal against the raw result
+		errIs           error // compared with errors.Is, for errors now wrapped in a ConfigError
+		missingRequired bool
+		district        string
+		collection      string
 	}{
 		{
 			context:  "normal case w/ flags",
@@ -33,13 +42,13 @@ func TestConfigure(t *testing.T) {
 			district: expectedDistrict,
 		},
 		{
-			context: "missing required field",
-			err:     errMissingDistrictField,
+			context:         "missing required field",
+			missingRequired: true,
 		},
 		{
-			context: "given other field but not required field",
-			args:    []string{"-collection=schools"},
-			err:     errMissingDistrictField,
+			context:         "given other field but not required field",
+			args:            []string{"-collection=schools"},
+			missingRequired: true,
 		},
 		{
 			context:  "normal case w/ json",
@@ -53,23 +62,26 @@ func TestConfigure(t *testing.T) {
 			collection: expectedCollection,
 		},
 		{
-			context: "empty JSON blob",
-			err:     errMissingDistrictField,
+			context:         "empty JSON blob",
+			args:            []string{`{}`},
+			missingRequired: true,
 		},
 		{
 			context: "fails with broken JSON",
 			args:    []string{`{"collection":"not closed, oops"`},
-			err:     ErrInvalidJSON,
+			errIs:   ErrInvalidJSON,
 		},
 		{
-			context: "only evaluates flags if provided first",
-			args:    []string{"-collection=schools", `{"district_id":"abc123"}`},
-			err:     errMissingDistrictField,
+			context:    "flags and JSON combine to fill different fields",
+			args:       []string{"-collection=schools", `{"district_id":"abc123"}`},
+			district:   expectedDistrict,
+			collection: expectedCollection,
 		},
 		{
-			context: "only evaluates flags if provided first",
-			args:    []string{"-collection=schools", `{"district_id":"abc123"}`},
-			err:     errMissingDistrictField,
+			context:    "flags win over JSON for the same field",
+			args:       []string{"-district_id=abc123", `{"district_id":"fromjson","collection":"schools"}`},
+			district:   expectedDistrict,
+			collection: expectedCollection,
 		},
 		{
 			context: "fails with non-declared flags",
@@ -86,11 +98,19 @@ func TestConfigure(t *testing.T) {
 			DistrictID string `config:"district_id,required"`
 			Collection string `config:"collection"`
 		}
-		if spec.err == nil && assert.NoError(t, Configure(&config), "Case '%s'", spec.context) {
-			assert.Equal(t, spec.district, config.DistrictID, "Case '%s'", spec.context)
-			assert.Equal(t, spec.collection, config.Collection, "Case '%s'", spec.context)
-		} else {
-			assert.Equal(t, spec.err, Configure(&config), "Case '%s'", spec.context)
+		result := Configure(&config)
+		switch {
+		case spec.missingRequired:
+			assert.True(t, errors.Is(result, ErrMissingRequired), "Case '%s'", spec.context)
+		case spec.errIs != nil:
+			assert.True(t, errors.Is(result, spec.errIs), "Case '%s': %v", spec.context, result)
+		case spec.err != nil:
+			assert.Equal(t, spec.err, result, "Case '%s'", spec.context)
+		default:
+			if assert.NoError(t, result, "Case '%s'", spec.context) {
+				assert.Equal(t, spec.district, config.DistrictID, "Case '%s'", spec.context)
+				assert.Equal(t, spec.collection, config.Collection, "Case '%s'", spec.context)
+			}
 		}
 	}
 }
@@ -103,18 +123,180 @@ func TestFailOnNoTag(t *testing.T) {
 		DistrictID string
 		Collection string `config:"collection,required"`
 	}
-	assert.Equal(t, ErrNoTagValue, Configure(&config))
+	assert.True(t, errors.Is(Configure(&config), ErrNoTagValue))
 }
 
 func TestFailOnTooManyTagValues(t *testing.T) {
 	os.Args = []string{"test", `{"district_id":"abc123","collection":"schools"}`}
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
+	var config struct {
+		DistrictID string `config:"district_id,required,env=DISTRICT_ID,EXTRA"`
+		Collection string `config:"collection"`
+	}
+	assert.True(t, errors.Is(Configure(&config), ErrTooManyTagValues))
+}
+
+func TestFailOnInvalidTagOption(t *testing.T) {
+	os.Args = []string{"test", `{"district_id":"abc123","collection":"schools"}`}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
 	var config struct {
 		DistrictID string `config:"district_id,required,EXTRA"`
 		Collection string `config:"collection"`
 	}
-	assert.Equal(t, ErrTooManyTagValues, Configure(&config))
+	assert.True(t, errors.Is(Configure(&config), ErrStructTagInvalidOption))
+}
+
+// TestAggregatesTagAndSourceErrors is the scenario a failed Source used to hide: a bad struct
+// tag and a broken JSON argument both need to show up in the same ConfigError, rather than the
+// source error bypassing the aggregate and burying the tag error.
+func TestAggregatesTagAndSourceErrors(t *testing.T) {
+	os.Args = []string{"test", `{"collection":"not closed, oops"`}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	var config struct {
+		DistrictID string
+		Collection string `config:"collection"`
+	}
+
+	var configErr *ConfigError
+	if assert.ErrorAs(t, Configure(&config), &configErr) {
+		assert.True(t, errors.Is(configErr, ErrNoTagValue))
+		assert.True(t, errors.Is(configErr, ErrInvalidJSON))
+	}
+}
+
+// fakeDecoder is a minimal FileDecoder used to test the -config/CONFIG_FILE source without
+// depending on one of the real format subpackages.
+type fakeDecoder struct{}
+
+func (fakeDecoder) Format() string { return "fake" }
+
+func (fakeDecoder) Decode(data []byte, out map[string]interface{}) error {
+	out["district_id"] = string(data)
+	return nil
+}
+
+func TestConfigFileFlag(t *testing.T) {
+	RegisterFileDecoder(fakeDecoder{})
+
+	dir := t.TempDir()
+	path := dir + "/config.fake"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("abc123"), 0644))
+
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+		Collection string `config:"collection"`
+	}
+
+	os.Args = []string{"test", "-config=" + path}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+}
+
+func TestConfigFileEnvVar(t *testing.T) {
+	RegisterFileDecoder(fakeDecoder{})
+
+	dir := t.TempDir()
+	path := dir + "/config.fake"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("abc123"), 0644))
+
+	os.Setenv("CONFIG_FILE", path)
+	defer os.Unsetenv("CONFIG_FILE")
+
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+		Collection string `config:"collection"`
+	}
+
+	os.Args = []string{"test"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+}
+
+func TestConfigFileLosesToFlag(t *testing.T) {
+	RegisterFileDecoder(fakeDecoder{})
+
+	dir := t.TempDir()
+	path := dir + "/config.fake"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("fromfile"), 0644))
+
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+		Collection string `config:"collection"`
+	}
+
+	os.Args = []string{"test", "-config=" + path, "-district_id=fromflag"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "fromflag", config.DistrictID)
+}
+
+// TestUnknownConfigFileFormat also checks that a failed source doesn't abort the whole
+// ConfigError aggregate: district_id is still reported missing alongside the file error.
+func TestUnknownConfigFileFormat(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+	}
+
+	os.Args = []string{"test", "-config=config.unknownformat"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	var configErr *ConfigError
+	if assert.ErrorAs(t, Configure(&config), &configErr) {
+		assert.Contains(t, configErr.Error(), fmt.Sprintf(noFileDecoderErrTemplate, "unknownformat"))
+		assert.True(t, errors.Is(configErr, ErrMissingRequired))
+	}
+}
+
+func TestEnvVarFallback(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required,env=DISTRICT_ID"`
+		Collection string `config:"collection"`
+	}
+
+	os.Setenv("DISTRICT_ID", "abc123")
+	defer os.Unsetenv("DISTRICT_ID")
+
+	os.Args = []string{"test"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+}
+
+func TestFlagsWinOverEnvVar(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required,env=DISTRICT_ID"`
+		Collection string `config:"collection"`
+	}
+
+	os.Setenv("DISTRICT_ID", "fromenv")
+	defer os.Unsetenv("DISTRICT_ID")
+
+	os.Args = []string{"test", "-district_id=fromflag"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "fromflag", config.DistrictID)
+}
+
+func TestEnvVarPrefix(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required,env=DISTRICT_ID"`
+		Collection string `config:"collection"`
+	}
+
+	SetEnvPrefix("APP_")
+	defer SetEnvPrefix("")
+	os.Setenv("APP_DISTRICT_ID", "abc123")
+	defer os.Unsetenv("APP_DISTRICT_ID")
+
+	os.Args = []string{"test"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
 }
 
 func TestBlankFlagValues(t *testing.T) {
@@ -185,3 +367,239 @@ func TestOverrideDefaultValues(t *testing.T) {
 	assert.Equal(t, "xyz", config.DistrictID)
 	assert.False(t, config.Dry)
 }
+
+func TestIntAndInt64Flags(t *testing.T) {
+	var config struct {
+		Port    int   `config:"port"`
+		MaxSize int64 `config:"max_size"`
+	}
+
+	os.Args = []string{"test", "-port=8080", "-max_size=1073741824"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, 8080, config.Port)
+	assert.Equal(t, int64(1073741824), config.MaxSize)
+}
+
+func TestDurationFlag(t *testing.T) {
+	var config struct {
+		Timeout time.Duration `config:"timeout"`
+	}
+
+	os.Args = []string{"test", "-timeout=30s"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, 30*time.Second, config.Timeout)
+}
+
+func TestStringSliceFlag(t *testing.T) {
+	var config struct {
+		Tags []string `config:"tags"`
+	}
+
+	os.Args = []string{"test", "-tags=a,b,c"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, []string{"a", "b", "c"}, config.Tags)
+}
+
+func TestStringMapFlag(t *testing.T) {
+	var config struct {
+		Labels map[string]string `config:"labels"`
+	}
+
+	os.Args = []string{"test", "-labels=env=prod,region=us-east-1"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, map[string]string{"env": "prod", "region": "us-east-1"}, config.Labels)
+}
+
+func TestInvalidStringMapFlag(t *testing.T) {
+	var config struct {
+		Labels map[string]string `config:"labels"`
+	}
+
+	os.Args = []string{"test", "-labels=notkeyvalue"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	// flag.FlagSet.Parse reports fieldFlag.Set's error via fmt.Errorf("... %v", err) rather than
+	// %w, so it can't be recovered with errors.Is/As; check the message instead.
+	assert.ErrorContains(t, Configure(&config), ErrInvalidMapValue.Error())
+}
+
+func TestNestedStructFlags(t *testing.T) {
+	var config struct {
+		DB struct {
+			Host string `config:"host,required"`
+			Port int    `config:"port"`
+		} `config:"db"`
+	}
+
+	os.Args = []string{"test", "-db.host=localhost", "-db.port=5432"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "localhost", config.DB.Host)
+	assert.Equal(t, 5432, config.DB.Port)
+}
+
+func TestNestedStructFromJSON(t *testing.T) {
+	var config struct {
+		DB struct {
+			Host string `config:"host,required"`
+			Port int    `config:"port"`
+		} `config:"db"`
+	}
+
+	os.Args = []string{"test", `{"db":{"host":"localhost","port":5432}}`}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, "localhost", config.DB.Host)
+	assert.Equal(t, 5432, config.DB.Port)
+}
+
+type level uint
+
+const (
+	levelLow level = iota
+	levelHigh
+)
+
+func TestRegisterParser(t *testing.T) {
+	// uint isn't natively supported, so this only works once a parser is registered for it.
+	RegisterParser(reflect.Uint, func(s string) (interface{}, error) {
+		if s == "high" {
+			return levelHigh, nil
+		}
+		return levelLow, nil
+	})
+	defer delete(customParsers, reflect.Uint)
+
+	var config struct {
+		Level level `config:"level"`
+	}
+
+	os.Args = []string{"test", "-level=high"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, Configure(&config))
+	assert.Equal(t, levelHigh, config.Level)
+}
+
+func TestUnregisteredCustomTypeFails(t *testing.T) {
+	var config struct {
+		Level level `config:"level"`
+	}
+
+	os.Args = []string{"test", "-level=high"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	// See the comment in TestInvalidStringMapFlag: flag.FlagSet.Parse reports this via %v, not
+	// %w, so it can't be recovered with errors.Is/As.
+	assert.ErrorContains(t, Configure(&config), ErrUnsupportedFieldType.Error())
+}
+
+// TestHelpOutput exercises -h/--help in a child process, since Configure calls os.Exit(0) when
+// help is requested.
+func TestHelpOutput(t *testing.T) {
+	if os.Getenv("CONFIGURE_HELP_CHILD") == "1" {
+		var config struct {
+			DistrictID string `config:"district_id,required" usage:"the district to operate on" description:"Unique district identifier" default:"none"`
+		}
+		os.Args = []string{"test", "-h"}
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		Configure(&config)
+		return
+	}
+
+	cmd := exec.Command(testBinaryPath, "-test.run=TestHelpOutput")
+	cmd.Env = append(os.Environ(), "CONFIGURE_HELP_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "district_id")
+	assert.Contains(t, string(out), "Unique district identifier")
+	assert.Contains(t, string(out), "REQUIRED")
+}
+
+// TestLoaderWithArgsAndEnv exercises WithArgs and WithEnv, which let a Loader be used without
+// touching the os.Args/os.Getenv globals at all.
+func TestLoaderWithArgsAndEnv(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required,env=DISTRICT_ID"`
+		Collection string `config:"collection"`
+	}
+
+	env := map[string]string{"DISTRICT_ID": "fromenv"}
+	loader := New(
+		WithArgs([]string{"-collection=schools"}),
+		WithEnv(func(name string) string { return env[name] }),
+	)
+	assert.NoError(t, loader.Load(&config))
+	assert.Equal(t, "fromenv", config.DistrictID)
+	assert.Equal(t, "schools", config.Collection)
+}
+
+// TestLoaderWithFS exercises WithFS, which lets -config/CONFIG_FILE be read from an fs.FS
+// instead of the host filesystem.
+func TestLoaderWithFS(t *testing.T) {
+	RegisterFileDecoder(fakeDecoder{})
+
+	fsys := fstest.MapFS{
+		"config.fake": &fstest.MapFile{Data: []byte("abc123")},
+	}
+
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+	}
+
+	loader := New(
+		WithArgs([]string{"-config=config.fake"}),
+		WithFS(fsys),
+	)
+	assert.NoError(t, loader.Load(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+}
+
+// TestLoaderWithFlagSet exercises WithFlagSet, which lets configure register its fields on a
+// FlagSet a larger CLI already owns, rather than one it creates itself.
+func TestLoaderWithFlagSet(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+	}
+
+	cliFlags := flag.NewFlagSet("mycli", flag.ContinueOnError)
+	verbose := cliFlags.Bool("verbose", false, "enable verbose logging")
+
+	loader := New(
+		WithArgs([]string{"-verbose", "-district_id=abc123"}),
+		WithFlagSet(cliFlags),
+	)
+	assert.NoError(t, loader.Load(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+	assert.True(t, *verbose)
+}
+
+// fakeSource is a minimal Source used to test WithSources.
+type fakeSource struct {
+	values map[string]interface{}
+}
+
+func (s fakeSource) Values() (map[string]interface{}, error) {
+	return s.values, nil
+}
+
+// TestLoaderWithSources exercises WithSources, checking that a custom Source fills fields not
+// already resolved by a flag.
+func TestLoaderWithSources(t *testing.T) {
+	var config struct {
+		DistrictID string `config:"district_id,required"`
+		Collection string `config:"collection"`
+	}
+
+	loader := New(
+		WithArgs([]string{"-collection=schools"}),
+		WithSources(fakeSource{values: map[string]interface{}{
+			"district_id": "fromsource",
+			"collection":  "shouldnotoverride",
+		}}),
+	)
+	assert.NoError(t, loader.Load(&config))
+	assert.Equal(t, "fromsource", config.DistrictID)
+	assert.Equal(t, "schools", config.Collection)
+}
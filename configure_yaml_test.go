@@ -0,0 +1,52 @@
+package configure_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Clever/configure"
+	_ "github.com/Clever/configure/yaml"
+)
+
+// TestConfigFileYAML exercises a real nested YAML config file end to end, via the registered
+// yaml.v2-backed FileDecoder rather than the fakeDecoder used elsewhere in this package's
+// internal tests. yaml.v2 decodes nested mappings as map[interface{}]interface{} instead of
+// map[string]interface{}, and toStringMap/lookupValue specifically handle that shape; this test
+// makes sure that path is actually exercised end to end, not just the hand-rolled fakeDecoder.
+// It lives in its own external (_test package) file because the yaml subpackage imports
+// configure, and an internal test file can't import something that imports the package under
+// test without creating a cycle.
+func TestConfigFileYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.yaml"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`
+district_id: abc123
+labels:
+  env: prod
+  team: data
+db:
+  host: localhost
+  port: 5432
+`), 0644))
+
+	var config struct {
+		DistrictID string            `config:"district_id,required"`
+		Labels     map[string]string `config:"labels"`
+		DB         struct {
+			Host string `config:"host,required"`
+			Port int    `config:"port"`
+		} `config:"db"`
+	}
+
+	os.Args = []string{"test", "-config=" + path}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	assert.NoError(t, configure.Configure(&config))
+	assert.Equal(t, "abc123", config.DistrictID)
+	assert.Equal(t, map[string]string{"env": "prod", "team": "data"}, config.Labels)
+	assert.Equal(t, "localhost", config.DB.Host)
+	assert.Equal(t, 5432, config.DB.Port)
+}
@@ -0,0 +1,30 @@
+// Package json registers a configure.FileDecoder for .json config files. Importing it for
+// side effects is enough to enable -config=foo.json / CONFIG_FILE=foo.json:
+//
+//	import _ "github.com/Clever/configure/json"
+package json
+
+import (
+	"encoding/json"
+
+	"github.com/Clever/configure"
+)
+
+func init() {
+	configure.RegisterFileDecoder(decoder{})
+}
+
+type decoder struct{}
+
+func (decoder) Format() string { return "json" }
+
+func (decoder) Decode(data []byte, out map[string]interface{}) error {
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	for k, v := range decoded {
+		out[k] = v
+	}
+	return nil
+}
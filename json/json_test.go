@@ -0,0 +1,29 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	out := map[string]interface{}{}
+	err := decoder{}.Decode([]byte(`{
+		"district_id": "abc123",
+		"port": 5432,
+		"db": {"host": "localhost", "port": 5432}
+	}`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", out["district_id"])
+	assert.Equal(t, float64(5432), out["port"])
+	assert.Equal(t, map[string]interface{}{"host": "localhost", "port": float64(5432)}, out["db"])
+}
+
+func TestDecodeInvalidJSON(t *testing.T) {
+	err := decoder{}.Decode([]byte(`{not valid json`), map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "json", decoder{}.Format())
+}
@@ -0,0 +1,30 @@
+// Package toml registers a configure.FileDecoder for .toml config files. Importing it for
+// side effects is enough to enable -config=foo.toml / CONFIG_FILE=foo.toml:
+//
+//	import _ "github.com/Clever/configure/toml"
+package toml
+
+import (
+	"github.com/BurntSushi/toml"
+
+	"github.com/Clever/configure"
+)
+
+func init() {
+	configure.RegisterFileDecoder(decoder{})
+}
+
+type decoder struct{}
+
+func (decoder) Format() string { return "toml" }
+
+func (decoder) Decode(data []byte, out map[string]interface{}) error {
+	decoded := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &decoded); err != nil {
+		return err
+	}
+	for k, v := range decoded {
+		out[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package toml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	out := map[string]interface{}{}
+	err := decoder{}.Decode([]byte(`
+district_id = "abc123"
+
+[db]
+host = "localhost"
+port = 5432
+`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", out["district_id"])
+	assert.Equal(t, map[string]interface{}{"host": "localhost", "port": int64(5432)}, out["db"])
+}
+
+func TestDecodeInvalidTOML(t *testing.T) {
+	err := decoder{}.Decode([]byte("not = [valid"), map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "toml", decoder{}.Format())
+}
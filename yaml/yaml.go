@@ -0,0 +1,29 @@
+// Package yaml registers a configure.FileDecoder for .yaml/.yml config files. Importing it
+// for side effects is enough to enable -config=foo.yaml / CONFIG_FILE=foo.yaml:
+//
+//	import _ "github.com/Clever/configure/yaml"
+package yaml
+
+import (
+	"github.com/Clever/configure"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func init() {
+	configure.RegisterFileDecoder(decoder{})
+}
+
+type decoder struct{}
+
+func (decoder) Format() string { return "yaml" }
+
+func (decoder) Decode(data []byte, out map[string]interface{}) error {
+	decoded := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	for k, v := range decoded {
+		out[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	out := map[string]interface{}{}
+	err := decoder{}.Decode([]byte(`
+district_id: abc123
+db:
+  host: localhost
+  port: 5432
+`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", out["district_id"])
+
+	// yaml.v2 decodes nested mappings into map[interface{}]interface{} rather than
+	// map[string]interface{}; configure.toStringMap/lookupValue specifically handle this, so
+	// pin down the shape here to catch a regression in either the decoder or the library's
+	// own type switch.
+	db, ok := out["db"].(map[interface{}]interface{})
+	if assert.True(t, ok, "nested yaml mapping should decode as map[interface{}]interface{}") {
+		assert.Equal(t, "localhost", db["host"])
+		assert.Equal(t, 5432, db["port"])
+	}
+}
+
+func TestDecodeInvalidYAML(t *testing.T) {
+	err := decoder{}.Decode([]byte("district_id: [unterminated"), map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "yaml", decoder{}.Format())
+}